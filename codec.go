@@ -0,0 +1,125 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/rocksolidlabs/afero"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec defines the on-disk encoding used to read and write records. It lets
+// JSONDB store records in formats other than JSON (BSON, MessagePack, ...)
+// while keeping the rest of the API untouched.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	Extension() string
+}
+
+// JSONCodec is the default Codec and encodes records using encoding/json,
+// the same on-disk format JSONDB has always used.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (JSONCodec) Extension() string { return "json" }
+
+// BSONCodec encodes records as BSON, as used by MongoDB and the scratch fork
+// of scribble this was modeled after.
+type BSONCodec struct{}
+
+func (BSONCodec) Marshal(v interface{}) ([]byte, error) { return bson.Marshal(v) }
+
+func (BSONCodec) Unmarshal(data []byte, v interface{}) error { return bson.Unmarshal(data, v) }
+
+func (BSONCodec) Extension() string { return "bson" }
+
+// MsgpackCodec encodes records as MessagePack.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+func (MsgpackCodec) Extension() string { return "msgpack" }
+
+// RegisterCodec associates a Codec with a specific collection, overriding
+// the database's default Codec for every file read or written under that
+// collection from this point on.
+func (db *JSONDB) RegisterCodec(collection string, codec Codec) {
+	db.codecMutex.Lock()
+	defer db.codecMutex.Unlock()
+	db.codecs[collection] = codec
+}
+
+// codecFor returns the Codec registered for collection, falling back to the
+// database's default Codec when none has been registered.
+func (db *JSONDB) codecFor(collection string) Codec {
+	db.codecMutex.Lock()
+	defer db.codecMutex.Unlock()
+	if codec, ok := db.codecs[collection]; ok {
+		return codec
+	}
+	return db.Codec
+}
+
+// MigrateCollection walks collection, re-encoding every resource from the
+// "from" Codec to the "to" Codec, and registers "to" as the collection's
+// Codec going forward. Use this to move an existing collection between
+// on-disk formats, e.g. from JSON to BSON.
+func (db *JSONDB) MigrateCollection(collection string, from, to Codec) error {
+	mutex := db.getOrCreateMutex(collection)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	dir := filepath.Join(db.Dir, collection)
+	files, err := afero.ReadDir(db.Fs, dir)
+	if err != nil {
+		return err
+	}
+
+	suffix := "." + from.Extension()
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), suffix) {
+			continue
+		}
+
+		oldPath := filepath.Join(dir, file.Name())
+		b, err := afero.ReadFile(db.Fs, oldPath)
+		if err != nil {
+			return err
+		}
+
+		var data map[string]interface{}
+		if err := from.Unmarshal(b, &data); err != nil {
+			return err
+		}
+
+		nb, err := to.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		resource := strings.TrimSuffix(file.Name(), suffix)
+		newPath := filepath.Join(dir, resource+"."+to.Extension())
+		if err := afero.WriteFile(db.Fs, newPath, nb, 0644); err != nil {
+			return err
+		}
+		if newPath != oldPath {
+			if err := db.Fs.Remove(oldPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	db.codecMutex.Lock()
+	db.codecs[collection] = to
+	db.codecMutex.Unlock()
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package jsondb
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rocksolidlabs/afero"
+)
+
+// incrementSeq disambiguates increments written within the same nanosecond
+// (possible on platforms with coarser clock resolution), keeping every
+// filename writeIncrement/writeMissingIncrement produces unique.
+var incrementSeq uint64
+
+// writeIncrement preserves the current contents of collection/resource under
+// <collection>/increments/<resource>.<timestamp>.<ext>, so it can still be
+// read via GetAt after Put overwrites it. It is a no-op if the resource
+// doesn't exist yet.
+func (db *JSONDB) writeIncrement(collection, resource, ext string) error {
+	dir := filepath.Join(db.Dir, collection)
+	path := filepath.Join(dir, resource+"."+ext)
+
+	old, err := afero.ReadFile(db.Fs, path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	incDir := filepath.Join(dir, "increments")
+	if err := db.Fs.MkdirAll(incDir, 0755); err != nil {
+		return err
+	}
+
+	incPath := filepath.Join(incDir, resource+"."+incrementTimestamp()+"."+ext)
+	return afero.WriteFile(db.Fs, incPath, old, 0644)
+}
+
+// writeMissingIncrement records that collection/resource was deleted, via an
+// empty <collection>/increments/<resource>.<timestamp>.missing marker.
+func (db *JSONDB) writeMissingIncrement(collection, resource string) error {
+	incDir := filepath.Join(db.Dir, collection, "increments")
+	if err := db.Fs.MkdirAll(incDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(incDir, resource+"."+incrementTimestamp()+".missing")
+	return afero.WriteFile(db.Fs, path, []byte{}, 0644)
+}
+
+// incrementTimestamp returns a filename-safe, monotonically increasing
+// timestamp segment: nanoseconds since the epoch plus a process-wide
+// sequence number. Two Puts within the same nanosecond still get distinct
+// segments, so neither increment silently overwrites the other. RFC3339 is
+// only second-resolution and isn't used here for that reason; a "." would
+// also collide with the "." the increment filename already uses as a field
+// separator.
+func incrementTimestamp() string {
+	seq := atomic.AddUint64(&incrementSeq, 1)
+	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.FormatUint(seq, 10)
+}
+
+// parseIncrementName splits an increment filename of the form
+// "<resource>.<unixnano>-<seq>.<ext|missing>" back into the resource it
+// belongs to and the time it was written. Resource is rejoined from every
+// part but the last two, since resource names (any non-empty string Put
+// accepts) may themselves contain dots.
+func parseIncrementName(name string) (resource string, ts time.Time, ok bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 3 {
+		return "", time.Time{}, false
+	}
+
+	nsPart := parts[len(parts)-2]
+	if i := strings.IndexByte(nsPart, '-'); i >= 0 {
+		nsPart = nsPart[:i]
+	}
+	ns, err := strconv.ParseInt(nsPart, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	resource = strings.Join(parts[:len(parts)-2], ".")
+	return resource, time.Unix(0, ns), true
+}
+
+// parseIncrementTimestamp pulls just the time out of an increment filename,
+// for callers (PruneIncrements) that don't need to know which resource it
+// belongs to.
+func parseIncrementTimestamp(name string) (time.Time, bool) {
+	_, ts, ok := parseIncrementName(name)
+	return ts, ok
+}
+
+// GetAt reconstructs collection/resource as it existed at time t and
+// unmarshals it into v. It scans the resource's increments, newest to
+// oldest, for the most recent one at or before t that isn't a ".missing"
+// marker; if none is found it falls back to the live record.
+func (db *JSONDB) GetAt(collection, resource string, t time.Time, v interface{}) error {
+	codec := db.codecFor(collection)
+	incDir := filepath.Join(db.Dir, collection, "increments")
+
+	entries, err := afero.ReadDir(db.Fs, incDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	type increment struct {
+		ts      time.Time
+		name    string
+		missing bool
+	}
+
+	var increments []increment
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		entryResource, ts, ok := parseIncrementName(name)
+		if !ok || entryResource != resource || ts.After(t) {
+			continue
+		}
+		increments = append(increments, increment{
+			ts:      ts,
+			name:    name,
+			missing: strings.HasSuffix(name, ".missing"),
+		})
+	}
+
+	sort.Slice(increments, func(i, j int) bool { return increments[i].ts.After(increments[j].ts) })
+
+	for _, inc := range increments {
+		if inc.missing {
+			continue
+		}
+		b, err := afero.ReadFile(db.Fs, filepath.Join(incDir, inc.name))
+		if err != nil {
+			return err
+		}
+		return codec.Unmarshal(b, v)
+	}
+
+	// no non-missing increment at or before t - use the live file, even if
+	// the record was overwritten or deleted after t
+	return db.Get(collection, resource, v)
+}
+
+// PruneIncrements removes every increment for collection older than keep,
+// relative to now.
+func (db *JSONDB) PruneIncrements(collection string, keep time.Duration) error {
+	incDir := filepath.Join(db.Dir, collection, "increments")
+
+	entries, err := afero.ReadDir(db.Fs, incDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	cutoff := time.Now().Add(-keep)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ts, ok := parseIncrementTimestamp(entry.Name())
+		if !ok || ts.After(cutoff) {
+			continue
+		}
+		if err := db.Fs.Remove(filepath.Join(incDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package jsondb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rocksolidlabs/afero"
+)
+
+type incrementsTestRecord struct {
+	Value string `json:"value"`
+}
+
+// TestGetAt_DoesNotMatchDottedResourcePrefix ensures GetAt("a", ...) can't
+// pick up increments belonging to a different resource, like "a.b", just
+// because "a.b.<ts>.json" happens to start with "a.".
+func TestGetAt_DoesNotMatchDottedResourcePrefix(t *testing.T) {
+	db, err := NewJSONDBWithFs(afero.NewMemMapFs(), "/data", nil, false, JSONCodec{})
+	if err != nil {
+		t.Fatalf("NewJSONDBWithFs: %v", err)
+	}
+	db.Increments = true
+
+	// "a" is never overwritten, so it has no increment of its own and
+	// GetAt must fall back to its live value.
+	if err := db.Put("widgets", "a", incrementsTestRecord{Value: "a-live"}); err != nil {
+		t.Fatalf("Put a: %v", err)
+	}
+
+	// "a.b" is overwritten, so it gets an increment archiving its first
+	// value. A prefix match on "a." would wrongly treat that as one of
+	// "a"'s own increments.
+	if err := db.Put("widgets", "a.b", incrementsTestRecord{Value: "a.b-1"}); err != nil {
+		t.Fatalf("Put a.b: %v", err)
+	}
+	if err := db.Put("widgets", "a.b", incrementsTestRecord{Value: "a.b-2"}); err != nil {
+		t.Fatalf("Put a.b (2nd): %v", err)
+	}
+
+	var got incrementsTestRecord
+	if err := db.GetAt("widgets", "a", time.Now(), &got); err != nil {
+		t.Fatalf("GetAt: %v", err)
+	}
+	if got.Value != "a-live" {
+		t.Fatalf("GetAt(%q) = %q, want %q (leaked resource %q's increment)", "a", got.Value, "a-live", "a.b")
+	}
+}
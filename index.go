@@ -0,0 +1,166 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rocksolidlabs/afero"
+	"github.com/rocksolidlabs/jsonq"
+)
+
+// indexKey normalizes a value into a stable index map key, so an index
+// built from decoded JSON (float64 numbers) matches lookups made with plain
+// Go ints.
+func indexKey(v interface{}) string {
+	if f, ok := toNumber(v); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func (db *JSONDB) indexPath(collection, path string) string {
+	return filepath.Join(db.Dir, collection, ".index", path+".json")
+}
+
+// loadIndex reads the secondary index for collection/path. exists is false
+// when no index has been created for that path.
+func (db *JSONDB) loadIndex(collection, path string) (idx map[string][]string, exists bool, err error) {
+	b, err := afero.ReadFile(db.Fs, db.indexPath(collection, path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string][]string{}, false, nil
+		}
+		return nil, false, err
+	}
+
+	idx = map[string][]string{}
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, false, err
+	}
+	return idx, true, nil
+}
+
+func (db *JSONDB) saveIndex(collection, path string, idx map[string][]string) error {
+	dir := filepath.Join(db.Dir, collection, ".index")
+	if err := db.Fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(db.Fs, db.indexPath(collection, path), b, 0644)
+}
+
+// CreateIndex builds a secondary index mapping every value found at path
+// (a dotted jsonq path) in collection to the resources holding it, so
+// db.Query can satisfy an EQ clause on path without a full scan.
+func (db *JSONDB) CreateIndex(collection, path string) error {
+	resources, err := db.Resources(collection)
+	if err != nil {
+		return err
+	}
+
+	idx := map[string][]string{}
+	for _, resource := range resources {
+		data, err := db.Data(collection, resource)
+		if err != nil {
+			return err
+		}
+		val, err := jsonq.NewQuery(data).Interface(strings.Split(path, ".")...)
+		if err != nil {
+			continue
+		}
+		key := indexKey(val)
+		idx[key] = append(idx[key], resource)
+	}
+
+	return db.saveIndex(collection, path, idx)
+}
+
+// RebuildIndex rebuilds an existing index for collection/path from scratch.
+// Use it after restoring a snapshot or bulk-loading data out-of-band, when
+// the on-disk index may no longer reflect what's in the collection.
+func (db *JSONDB) RebuildIndex(collection, path string) error {
+	return db.CreateIndex(collection, path)
+}
+
+// updateIndexes keeps every index already created on collection in sync
+// with a single Put (removed is false) or Delete (removed is true) of
+// resource.
+func (db *JSONDB) updateIndexes(collection, resource string, data map[string]interface{}, removed bool) error {
+	indexDir := filepath.Join(db.Dir, collection, ".index")
+	files, err := afero.ReadDir(db.Fs, indexDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		path := strings.TrimSuffix(file.Name(), ".json")
+		if err := db.updateIndex(collection, path, resource, data, removed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *JSONDB) updateIndex(collection, path, resource string, data map[string]interface{}, removed bool) error {
+	idx, _, err := db.loadIndex(collection, path)
+	if err != nil {
+		return err
+	}
+
+	// drop resource from wherever it currently sits in the index
+	for key, ids := range idx {
+		idx[key] = removeResourceID(ids, resource)
+		if len(idx[key]) == 0 {
+			delete(idx, key)
+		}
+	}
+
+	if !removed {
+		if val, err := jsonq.NewQuery(data).Interface(strings.Split(path, ".")...); err == nil {
+			key := indexKey(val)
+			idx[key] = append(idx[key], resource)
+		}
+	}
+
+	return db.saveIndex(collection, path, idx)
+}
+
+func removeResourceID(ids []string, resource string) []string {
+	out := ids[:0]
+	for _, id := range ids {
+		if id != resource {
+			out = append(out, id)
+		}
+	}
+	return out
+}
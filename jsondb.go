@@ -3,9 +3,7 @@ package jsondb
 import (
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -13,21 +11,40 @@ import (
 	"sync"
 
 	"github.com/intwinelabs/logger"
+	"github.com/rocksolidlabs/afero"
 	"github.com/rocksolidlabs/jsonq"
 )
 
 const Version = "0.0.1"
 
 type JSONDB struct {
-	Trace   bool
-	Logger  *logger.Logger
-	Dir     string
-	mutex   sync.Mutex
-	mutexes map[string]sync.Mutex
+	Trace      bool
+	Logger     *logger.Logger
+	Dir        string
+	Fs         afero.Fs
+	Codec      Codec
+	Increments bool
+	mutex      sync.Mutex
+	mutexes    map[string]*sync.Mutex
+	codecMutex sync.Mutex
+	codecs     map[string]Codec
 }
 
-// Create a new JSONDB instance using os FS
+// Create a new JSONDB instance using os FS, encoding records as JSON
 func NewJSONDB(datadir string, log *logger.Logger, trace bool) (*JSONDB, error) {
+	return NewJSONDBWithFs(afero.NewOsFs(), datadir, log, trace, JSONCodec{})
+}
+
+// Create a new JSONDB instance using os FS, encoding records with the given Codec
+func NewJSONDBWithCodec(datadir string, log *logger.Logger, trace bool, codec Codec) (*JSONDB, error) {
+	return NewJSONDBWithFs(afero.NewOsFs(), datadir, log, trace, codec)
+}
+
+// Create a new JSONDB instance backed by fs, encoding records with the given
+// Codec. Passing an afero.NewMemMapFs() gives an in-memory database useful
+// for tests; passing an aferorepwr.ReplicateOnWriteFs mirrors every write to
+// a backup/remote layer.
+func NewJSONDBWithFs(fs afero.Fs, datadir string, log *logger.Logger, trace bool, codec Codec) (*JSONDB, error) {
 
 	dir := filepath.Clean(datadir + "/db")
 
@@ -36,14 +53,20 @@ func NewJSONDB(datadir string, log *logger.Logger, trace bool) (*JSONDB, error)
 		Trace:   trace,
 		Logger:  log,
 		Dir:     dir,
-		mutexes: make(map[string]sync.Mutex),
+		Fs:      fs,
+		Codec:   codec,
+		mutexes: make(map[string]*sync.Mutex),
+		codecs:  make(map[string]Codec),
 	}
 
 	// if the database already exists, just use it
-	if _, err := os.Stat(dir); err == nil {
+	if _, err := db.Fs.Stat(dir); err == nil {
 		if trace {
 			db.Logger.Info("Using '%s' (database already exists)\n", dir)
 		}
+		if err := db.replayWAL(); err != nil {
+			return nil, err
+		}
 		return db, nil
 	}
 
@@ -51,7 +74,7 @@ func NewJSONDB(datadir string, log *logger.Logger, trace bool) (*JSONDB, error)
 	if trace {
 		db.Logger.Info("Creating database at '%s'...\n", dir)
 	}
-	err := os.MkdirAll(dir, 0755)
+	err := db.Fs.MkdirAll(dir, 0755)
 	if err != nil {
 		return nil, err
 	}
@@ -69,7 +92,7 @@ func (db *JSONDB) InitCollection(collection string) error {
 	dir := filepath.Join(db.Dir, collection)
 
 	// create collection directory
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := db.Fs.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
@@ -94,29 +117,66 @@ func (db *JSONDB) Put(collection, resource string, v interface{}) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	return db.putLocked(collection, resource, v)
+}
+
+// putLocked performs the actual tmp-file+rename write for Put. Callers must
+// already hold collection's mutex. Tx.Commit skips this and calls
+// putLockedBytes, since its Put ops are already encoded.
+func (db *JSONDB) putLocked(collection, resource string, v interface{}) error {
+	codec := db.codecFor(collection)
+
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return db.putLockedBytes(collection, resource, b)
+}
+
+// putLockedBytes writes b, already encoded with collection's Codec, to
+// resource. Tx buffers its Put ops pre-marshaled so replay doesn't need to
+// round-trip the value through a generic interface{} to re-encode it.
+func (db *JSONDB) putLockedBytes(collection, resource string, b []byte) error {
+	codec := db.codecFor(collection)
+
 	//
 	dir := filepath.Join(db.Dir, collection)
-	fnlPath := filepath.Join(dir, resource+".json")
+	fnlPath := filepath.Join(dir, resource+"."+codec.Extension())
 	tmpPath := fnlPath + ".tmp"
 
 	// create collection directory
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := db.Fs.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
 
-	//
-	b, err := json.Marshal(v)
-	if err != nil {
-		return err
+	// if increments mode is enabled, preserve whatever this Put is about to
+	// overwrite so GetAt can reconstruct the record's prior states
+	if db.Increments {
+		if err := db.writeIncrement(collection, resource, codec.Extension()); err != nil {
+			return err
+		}
 	}
 
 	// write marshaled data to the temp file
-	if err := ioutil.WriteFile(tmpPath, b, 0644); err != nil {
+	if err := afero.WriteFile(db.Fs, tmpPath, b, 0644); err != nil {
 		return err
 	}
 
 	// move final file into place
-	return os.Rename(tmpPath, fnlPath)
+	if err := db.Fs.Rename(tmpPath, fnlPath); err != nil {
+		return err
+	}
+
+	// keep any secondary indexes on this collection in sync
+	var data map[string]interface{}
+	if err := codec.Unmarshal(b, &data); err == nil {
+		if err := db.updateIndexes(collection, resource, data, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // Get a record from the database and marshal it to the passed object
@@ -132,22 +192,24 @@ func (db *JSONDB) Get(collection, resource string, record interface{}) error {
 		return fmt.Errorf("Missing resource - unable to save record (no name)!")
 	}
 
+	codec := db.codecFor(collection)
+
 	//
 	rec := filepath.Join(db.Dir, collection, resource)
 
 	// check to see if file exists
-	if _, err := db.Stat(rec); err != nil {
+	if _, err := db.Stat(rec, codec.Extension()); err != nil {
 		return err
 	}
 
 	// read record from database
-	b, err := ioutil.ReadFile(rec+".json")
+	b, err := afero.ReadFile(db.Fs, rec+"."+codec.Extension())
 	if err != nil {
 		return err
 	}
 
 	// unmarshal data
-	return json.Unmarshal(b, &record)
+	return codec.Unmarshal(b, &record)
 }
 
 // Get a record from the database and return the JSON byte array
@@ -163,16 +225,18 @@ func (db *JSONDB) GetBytes(collection, resource string) ([]byte, error) {
 		return nil, fmt.Errorf("Missing resource - unable to save record (no name)!")
 	}
 
+	codec := db.codecFor(collection)
+
 	//
 	rec := filepath.Join(db.Dir, collection, resource)
 
 	// check to see if file exists
-	if _, err := db.Stat(rec); err != nil {
+	if _, err := db.Stat(rec, codec.Extension()); err != nil {
 		return nil, err
 	}
 
 	// read record from database
-	b, err := ioutil.ReadFile(rec+".json")
+	b, err := afero.ReadFile(db.Fs, rec+"."+codec.Extension())
 	if err != nil {
 		return nil, err
 	}
@@ -190,17 +254,19 @@ func (db *JSONDB) GetWhere(collection, query string, expression, records interfa
 		return fmt.Errorf("Missing collection - unable to record location!")
 	}
 
+	codec := db.codecFor(collection)
+
 	//
 	dir := filepath.Join(db.Dir, collection)
 
 	// check to see if collection (directory) exists
-	if _, err := db.Stat(dir); err != nil {
+	if _, err := db.Stat(dir, ""); err != nil {
 		return err
 	}
 
 	// read all the files in the transaction.Collection; an error here just means
 	// the collection is either empty or doesn't exist
-	files, _ := ioutil.ReadDir(dir)
+	files := listResourceFiles(db.Fs, dir, codec.Extension())
 
 	// get the type of the records
 	rt, err := toSliceType(records)
@@ -215,14 +281,13 @@ func (db *JSONDB) GetWhere(collection, query string, expression, records interfa
 	// append the cast records to the passed collection slice
 	for _, file := range files {
 		// read the file bytes
-		fileBytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		fileBytes, err := afero.ReadFile(db.Fs, filepath.Join(dir, file.Name()))
 		if err != nil {
 			return err
 		}
 		// check the query to see if the record matches
 		data := map[string]interface{}{}
-		dec := json.NewDecoder(strings.NewReader(string(fileBytes)))
-		dec.Decode(&data)
+		codec.Unmarshal(fileBytes, &data)
 		jq := jsonq.NewQuery(data)
 		var queryPath []string
 		if strings.Index(query, ".") > -1 {
@@ -257,7 +322,7 @@ func (db *JSONDB) GetWhere(collection, query string, expression, records interfa
 			// and Unmarshal the JSON bytes from the file
 			record := reflect.New(rt)
 			recordInterface := record.Interface()
-			err = json.Unmarshal(fileBytes, &recordInterface)
+			err = codec.Unmarshal(fileBytes, &recordInterface)
 			if err != nil {
 				return err
 			}
@@ -286,17 +351,19 @@ func (db *JSONDB) GetWhereNot(collection, query string, expression, records inte
 		return fmt.Errorf("Missing collection - unable to record location!")
 	}
 
+	codec := db.codecFor(collection)
+
 	//
 	dir := filepath.Join(db.Dir, collection)
 
 	// check to see if collection (directory) exists
-	if _, err := db.Stat(dir); err != nil {
+	if _, err := db.Stat(dir, ""); err != nil {
 		return err
 	}
 
 	// read all the files in the transaction.Collection; an error here just means
 	// the collection is either empty or doesn't exist
-	files, _ := ioutil.ReadDir(dir)
+	files := listResourceFiles(db.Fs, dir, codec.Extension())
 
 	// get the type of the records
 	rt, err := toSliceType(records)
@@ -311,14 +378,13 @@ func (db *JSONDB) GetWhereNot(collection, query string, expression, records inte
 	// append the cast records to the passed collection slice
 	for _, file := range files {
 		// read the file bytes
-		fileBytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		fileBytes, err := afero.ReadFile(db.Fs, filepath.Join(dir, file.Name()))
 		if err != nil {
 			return err
 		}
 		// check the query to see if the record matches
 		data := map[string]interface{}{}
-		dec := json.NewDecoder(strings.NewReader(string(fileBytes)))
-		dec.Decode(&data)
+		codec.Unmarshal(fileBytes, &data)
 		jq := jsonq.NewQuery(data)
 		var queryPath []string
 		if strings.Index(query, ".") > -1 {
@@ -352,7 +418,7 @@ func (db *JSONDB) GetWhereNot(collection, query string, expression, records inte
 			// and Unmarshal the JSON bytes from the file
 			record := reflect.New(rt)
 			recordInterface := record.Interface()
-			err = json.Unmarshal(fileBytes, &recordInterface)
+			err = codec.Unmarshal(fileBytes, &recordInterface)
 			if err != nil {
 				return err
 			}
@@ -380,18 +446,20 @@ func (db *JSONDB) GetAll(collection string, records interface{}) error {
 		return fmt.Errorf("Missing collection - unable to record location!")
 	}
 
+	codec := db.codecFor(collection)
+
 	//
 	dir := filepath.Join(db.Dir, collection)
 
 	// check to see if collection (directory) exists
-	if _, err := db.Stat(dir); err != nil {
+	if _, err := db.Stat(dir, ""); err != nil {
 		db.Logger.Errorf("Error: %+v", err)
 		return err
 	}
 
 	// read all the files in the transaction.Collection; an error here just means
 	// the collection is either empty or doesn't exist
-	files, _ := ioutil.ReadDir(dir)
+	files := listResourceFiles(db.Fs, dir, codec.Extension())
 
 	// get the type of the records
 	rt, err := toSliceType(records)
@@ -404,7 +472,7 @@ func (db *JSONDB) GetAll(collection string, records interface{}) error {
 	// append the cast records to the passed collection slice
 	for _, file := range files {
 		// read the file bytes
-		fileBytes, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		fileBytes, err := afero.ReadFile(db.Fs, filepath.Join(dir, file.Name()))
 		if err != nil {
 			db.Logger.Errorf("Error: %+v", err)
 			return err
@@ -413,7 +481,7 @@ func (db *JSONDB) GetAll(collection string, records interface{}) error {
 		// and Unmarshal the JSON bytes from the file
 		record := reflect.New(rt)
 		recordInterface := record.Interface()
-		err = json.Unmarshal(fileBytes, &recordInterface)
+		err = codec.Unmarshal(fileBytes, &recordInterface)
 		if err != nil {
 			db.Logger.Errorf("Error: %+v", err)
 			return err
@@ -436,9 +504,18 @@ func (db *JSONDB) Delete(collection, resource string) error {
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	return db.deleteLocked(collection, resource)
+}
+
+// deleteLocked performs the actual removal for Delete. Callers must already
+// hold the collection/resource mutex; Tx.Commit uses this directly.
+func (db *JSONDB) deleteLocked(collection, resource string) error {
+	path := filepath.Join(collection, resource)
+	ext := db.codecFor(collection).Extension()
+
 	dir := filepath.Join(db.Dir, path)
 
-	switch fi, err := db.Stat(dir); {
+	switch fi, err := db.Stat(dir, ext); {
 
 	// if fi is nil or error is not nil return
 	case fi == nil, err != nil:
@@ -446,30 +523,49 @@ func (db *JSONDB) Delete(collection, resource string) error {
 
 	// remove directory and all contents
 	case fi.Mode().IsDir():
-		return os.RemoveAll(dir)
+		return db.Fs.RemoveAll(dir)
 
 	// remove file
 	case fi.Mode().IsRegular():
-		return os.RemoveAll(dir + ".json")
+		if db.Increments {
+			if err := db.writeMissingIncrement(collection, resource); err != nil {
+				return err
+			}
+		}
+		if err := db.updateIndexes(collection, resource, nil, true); err != nil {
+			return err
+		}
+		return db.Fs.RemoveAll(dir + "." + ext)
 	}
 
 	return nil
 }
 
-// Link locks that database and then creates a link to a existing resource
+// Link locks that database and then creates a link to a existing resource.
+// When the underlying Fs supports symlinks (as afero.OsFs does) a real
+// symlink is created; otherwise (e.g. afero.MemMapFs) the resource is copied.
 func (db *JSONDB) Link(srcCollection, srcResource, destCollection, destResource string) error {
 	srcPath := filepath.Join(srcCollection, srcResource)
-	destPath := filepath.Join(destCollection, destResource)
 
 	// lock the JSONDB
 	mutex := db.getOrCreateMutex(srcPath)
 	mutex.Lock()
 	defer mutex.Unlock()
 
+	return db.linkLocked(srcCollection, srcResource, destCollection, destResource)
+}
+
+// linkLocked performs the actual link/copy for Link. Callers must already
+// hold the source mutex; Tx.Commit uses this directly.
+func (db *JSONDB) linkLocked(srcCollection, srcResource, destCollection, destResource string) error {
+	srcPath := filepath.Join(srcCollection, srcResource)
+	destPath := filepath.Join(destCollection, destResource)
+	ext := db.codecFor(srcCollection).Extension()
+
 	src := filepath.Join(db.Dir, srcPath)
 	dest := filepath.Join(db.Dir, destPath)
 
-	switch fi, err := db.Stat(src); {
+	switch fi, err := db.Stat(src, ext); {
 
 	// if fi is nil or error is not nil return
 	case fi == nil, err != nil:
@@ -477,32 +573,95 @@ func (db *JSONDB) Link(srcCollection, srcResource, destCollection, destResource
 
 	// link a collection
 	case fi.Mode().IsDir():
-		return os.Symlink(src, dest)
+		return db.symlinkOrCopy(src, dest)
 
 	// link file
 	case fi.Mode().IsRegular():
-		os.Remove(dest + ".json")
-		return os.Symlink(src+".json", dest+".json")
+		db.Fs.Remove(dest + "." + ext)
+		return db.symlinkOrCopy(src+"."+ext, dest+"."+ext)
 
 	}
 
 	return nil
 }
 
-//
-func (db *JSONDB) Stat(path string) (fi os.FileInfo, err error) {
+// symlinker is implemented by afero.Fs implementations that can create real
+// symlinks, e.g. afero.OsFs. afero.MemMapFs does not implement it.
+type symlinker interface {
+	SymlinkIfPossible(oldname, newname string) error
+}
+
+// symlinkOrCopy creates a symlink from newname to oldname when db.Fs supports
+// it, falling back to copying the file or directory tree otherwise.
+func (db *JSONDB) symlinkOrCopy(oldname, newname string) error {
+	if sl, ok := db.Fs.(symlinker); ok {
+		return sl.SymlinkIfPossible(oldname, newname)
+	}
+
+	fi, err := db.Fs.Stat(oldname)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return afero.Walk(db.Fs, oldname, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(oldname, path)
+			if err != nil {
+				return err
+			}
+			target := filepath.Join(newname, rel)
+			if info.IsDir() {
+				return db.Fs.MkdirAll(target, info.Mode())
+			}
+			b, err := afero.ReadFile(db.Fs, path)
+			if err != nil {
+				return err
+			}
+			return afero.WriteFile(db.Fs, target, b, info.Mode())
+		})
+	}
+
+	b, err := afero.ReadFile(db.Fs, oldname)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(db.Fs, newname, b, fi.Mode())
+}
+
+// Stat checks for the existence of path, first as a literal match and then,
+// when ext is non-empty, as a file suffixed with the given codec extension
+// (e.g. "json", "bson", "msgpack").
+func (db *JSONDB) Stat(path, ext string) (fi os.FileInfo, err error) {
 
 	// check for dir, if path isn't a directory check to see if it's a file
-	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")
+	if fi, err = db.Fs.Stat(path); os.IsNotExist(err) && ext != "" {
+		fi, err = db.Fs.Stat(path + "." + ext)
 	}
 
 	return
 }
 
+// listResourceFiles returns the files in dir whose name carries the given
+// codec extension, skipping anything else (directories, other codecs, tmp
+// files left over from an interrupted Put).
+func listResourceFiles(fs afero.Fs, dir, ext string) []os.FileInfo {
+	all, _ := afero.ReadDir(fs, dir)
+	suffix := "." + ext
+	files := make([]os.FileInfo, 0, len(all))
+	for _, file := range all {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), suffix) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
 // getOrCreateMutex creates a new collection specific mutex any time a collection
 // is being modfied to avoid unsafe operations
-func (db *JSONDB) getOrCreateMutex(collection string) sync.Mutex {
+func (db *JSONDB) getOrCreateMutex(collection string) *sync.Mutex {
 
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
@@ -511,7 +670,7 @@ func (db *JSONDB) getOrCreateMutex(collection string) sync.Mutex {
 
 	// if the mutex doesn't exist make it
 	if !ok {
-		m = sync.Mutex{}
+		m = &sync.Mutex{}
 		db.mutexes[collection] = m
 	}
 
@@ -0,0 +1,397 @@
+// Package query implements a small query DSL for JSONDB collections:
+//
+//	var people []*Person
+//	err := db.Query("people").
+//		Where("age", query.GTE, 18).
+//		And("lname", query.EQ, "Rizen").
+//		Sort("age", true).
+//		Limit(10).
+//		Find(&people)
+//
+// Clauses are evaluated left to right against each resource's dotted jsonq
+// path, combined with the boolean operator the clause was added with
+// (Where/And for AND, Or for OR). When the query is a pure conjunction (no
+// Or clauses) and the first clause is an EQ against a path with a secondary
+// index (see the JSONDB CreateIndex method), the builder consults the index
+// instead of scanning every resource.
+package query
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rocksolidlabs/jsonq"
+)
+
+// Op is a comparison operator usable in a Where/And/Or clause.
+type Op string
+
+const (
+	EQ       Op = "eq"
+	NE       Op = "ne"
+	LT       Op = "lt"
+	LTE      Op = "lte"
+	GT       Op = "gt"
+	GTE      Op = "gte"
+	IN       Op = "in"
+	CONTAINS Op = "contains"
+	REGEX    Op = "regex"
+	EXISTS   Op = "exists"
+)
+
+// Cond is a single comparison against a dotted jsonq path.
+type Cond struct {
+	Path  string
+	Op    Op
+	Value interface{}
+}
+
+// clause is one term of the query's boolean expression: a Cond, ANDed or
+// ORed with the clauses before it.
+type clause struct {
+	cond Cond
+	or   bool
+}
+
+// sortBy orders results by a dotted jsonq path.
+type sortBy struct {
+	path string
+	asc  bool
+}
+
+// Source is implemented by a database capable of running a Query.
+type Source interface {
+	// Resources returns every resource name currently stored in collection.
+	Resources(collection string) ([]string, error)
+	// Data decodes a resource generically, for filtering and sorting.
+	Data(collection, resource string) (map[string]interface{}, error)
+	// Decode unmarshals a resource's contents into v.
+	Decode(collection, resource string, v interface{}) error
+	// IndexLookup returns the resource names recorded under value in the
+	// secondary index for collection/path, and whether that index exists at
+	// all. When ok is false the builder falls back to a full scan.
+	IndexLookup(collection, path string, value interface{}) (ids []string, ok bool, err error)
+}
+
+// Builder builds and runs a Query against a collection.
+type Builder struct {
+	source     Source
+	collection string
+	clauses    []clause
+	sorts      []sortBy
+	limit      int
+	offset     int
+}
+
+// New returns a Builder that queries collection through source. Use
+// JSONDB.Query instead of calling this directly.
+func New(source Source, collection string) *Builder {
+	return &Builder{source: source, collection: collection, limit: -1}
+}
+
+// Where adds the first comparison of the query.
+func (b *Builder) Where(path string, op Op, value interface{}) *Builder {
+	b.clauses = append(b.clauses, clause{cond: Cond{Path: path, Op: op, Value: value}})
+	return b
+}
+
+// And adds a comparison that must also hold.
+func (b *Builder) And(path string, op Op, value interface{}) *Builder {
+	b.clauses = append(b.clauses, clause{cond: Cond{Path: path, Op: op, Value: value}})
+	return b
+}
+
+// Or adds a comparison where either it or everything before it may hold.
+func (b *Builder) Or(path string, op Op, value interface{}) *Builder {
+	b.clauses = append(b.clauses, clause{cond: Cond{Path: path, Op: op, Value: value}, or: true})
+	return b
+}
+
+// Sort orders results by path. Multiple Sort calls break ties in order.
+func (b *Builder) Sort(path string, asc bool) *Builder {
+	b.sorts = append(b.sorts, sortBy{path: path, asc: asc})
+	return b
+}
+
+// Limit caps the number of results Find returns. Pass a negative number for
+// no limit (the default).
+func (b *Builder) Limit(n int) *Builder {
+	b.limit = n
+	return b
+}
+
+// Offset skips the first n matching results, for pagination.
+func (b *Builder) Offset(n int) *Builder {
+	b.offset = n
+	return b
+}
+
+// Find runs the query and appends every matching record to records, which
+// must be a pointer to a slice.
+func (b *Builder) Find(records interface{}) error {
+	rt, err := toSliceType(records)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := b.candidates()
+	if err != nil {
+		return err
+	}
+
+	type match struct {
+		resource string
+		data     map[string]interface{}
+	}
+	matches := make([]match, 0, len(candidates))
+	for _, resource := range candidates {
+		data, err := b.source.Data(b.collection, resource)
+		if err != nil {
+			return err
+		}
+		if b.evaluate(data) {
+			matches = append(matches, match{resource: resource, data: data})
+		}
+	}
+
+	if len(b.sorts) > 0 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			for _, s := range b.sorts {
+				c := compareValues(valueAt(matches[i].data, s.path), valueAt(matches[j].data, s.path))
+				if c == 0 {
+					continue
+				}
+				if s.asc {
+					return c < 0
+				}
+				return c > 0
+			}
+			return false
+		})
+	}
+
+	if b.offset > 0 {
+		if b.offset >= len(matches) {
+			matches = nil
+		} else {
+			matches = matches[b.offset:]
+		}
+	}
+	if b.limit >= 0 && b.limit < len(matches) {
+		matches = matches[:b.limit]
+	}
+
+	recordsVal := reflect.ValueOf(records).Elem()
+	for _, m := range matches {
+		record := reflect.New(rt)
+		if err := b.source.Decode(b.collection, m.resource, record.Interface()); err != nil {
+			return err
+		}
+		recordsVal.Set(reflect.Append(recordsVal, record.Elem()))
+	}
+
+	return nil
+}
+
+// candidates returns the resources the query needs to evaluate: the index
+// lookup for the first clause when it's an EQ against an indexed path and
+// every other clause is ANDed in, otherwise every resource in the
+// collection. An index lookup only narrows candidates for a pure
+// conjunction; if any clause is ORed in, a record can match without
+// satisfying the first clause at all, so the index can't be trusted to
+// produce a superset of the results.
+func (b *Builder) candidates() ([]string, error) {
+	if len(b.clauses) > 0 && b.clauses[0].cond.Op == EQ && !b.hasOr() {
+		first := b.clauses[0].cond
+		if ids, ok, err := b.source.IndexLookup(b.collection, first.Path, first.Value); err != nil {
+			return nil, err
+		} else if ok {
+			return ids, nil
+		}
+	}
+	return b.source.Resources(b.collection)
+}
+
+// hasOr reports whether any clause after the first was added via Or.
+func (b *Builder) hasOr() bool {
+	for _, c := range b.clauses[1:] {
+		if c.or {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate applies every clause to data, left to right, combining with AND
+// unless the clause was added via Or.
+func (b *Builder) evaluate(data map[string]interface{}) bool {
+	if len(b.clauses) == 0 {
+		return true
+	}
+
+	result := evalCond(data, b.clauses[0].cond)
+	for _, c := range b.clauses[1:] {
+		if c.or {
+			result = result || evalCond(data, c.cond)
+		} else {
+			result = result && evalCond(data, c.cond)
+		}
+	}
+	return result
+}
+
+func valueAt(data map[string]interface{}, path string) interface{} {
+	val, err := jsonq.NewQuery(data).Interface(strings.Split(path, ".")...)
+	if err != nil {
+		return nil
+	}
+	return val
+}
+
+func evalCond(data map[string]interface{}, cond Cond) bool {
+	val, err := jsonq.NewQuery(data).Interface(strings.Split(cond.Path, ".")...)
+
+	if cond.Op == EXISTS {
+		return err == nil
+	}
+	if err != nil {
+		return false
+	}
+
+	switch cond.Op {
+	case EQ:
+		return equalValues(val, cond.Value)
+	case NE:
+		return !equalValues(val, cond.Value)
+	case LT:
+		return compareValues(val, cond.Value) < 0
+	case LTE:
+		return compareValues(val, cond.Value) <= 0
+	case GT:
+		return compareValues(val, cond.Value) > 0
+	case GTE:
+		return compareValues(val, cond.Value) >= 0
+	case IN:
+		return inSlice(val, cond.Value)
+	case CONTAINS:
+		return containsValue(val, cond.Value)
+	case REGEX:
+		return matchRegex(val, cond.Value)
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// equalValues compares a and b, treating any two numeric types as equal
+// when their values match (jsonq decodes numbers as float64, but callers
+// often pass plain ints).
+func equalValues(a, b interface{}) bool {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			return af == bf
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders a relative to b: -1 if a < b, 1 if a > b, 0 if equal
+// or not comparable.
+func compareValues(a, b interface{}) int {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			switch {
+			case af < bf:
+				return -1
+			case af > bf:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs)
+		}
+	}
+	return 0
+}
+
+func inSlice(val, list interface{}) bool {
+	lv := reflect.ValueOf(list)
+	if lv.Kind() != reflect.Slice {
+		return false
+	}
+	for i := 0; i < lv.Len(); i++ {
+		if equalValues(val, lv.Index(i).Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsValue(val, needle interface{}) bool {
+	switch v := val.(type) {
+	case string:
+		s, ok := needle.(string)
+		return ok && strings.Contains(v, s)
+	case []interface{}:
+		for _, item := range v {
+			if equalValues(item, needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchRegex(val, pattern interface{}) bool {
+	s, ok := val.(string)
+	if !ok {
+		return false
+	}
+	p, ok := pattern.(string)
+	if !ok {
+		return false
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+func toSliceType(i interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(i)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Slice {
+		return nil, &InvalidTargetError{Type: t}
+	}
+	return t.Elem().Elem(), nil
+}
+
+// InvalidTargetError is returned by Find when records is not a pointer to a
+// slice.
+type InvalidTargetError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidTargetError) Error() string {
+	return "query: Find needs a pointer to a slice, got " + e.Type.String()
+}
@@ -0,0 +1,114 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// fakeSource is a minimal in-memory Source for exercising the Builder
+// without a real JSONDB.
+type fakeSource struct {
+	data    map[string]map[string]interface{} // resource -> record
+	indexed map[string]map[string][]string    // path -> value -> resources
+	scanned bool                              // set once Resources is called
+}
+
+func (s *fakeSource) Resources(collection string) ([]string, error) {
+	s.scanned = true
+	ids := make([]string, 0, len(s.data))
+	for id := range s.data {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *fakeSource) Data(collection, resource string) (map[string]interface{}, error) {
+	return s.data[resource], nil
+}
+
+func (s *fakeSource) Decode(collection, resource string, v interface{}) error {
+	b, err := json.Marshal(s.data[resource])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *fakeSource) IndexLookup(collection, path string, value interface{}) ([]string, bool, error) {
+	byValue, ok := s.indexed[path]
+	if !ok {
+		return nil, false, nil
+	}
+	key, _ := json.Marshal(value)
+	return byValue[string(key)], true, nil
+}
+
+type person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func newPeopleSource() *fakeSource {
+	src := &fakeSource{
+		data: map[string]map[string]interface{}{
+			"alice": {"name": "alice", "age": 18.0},
+			"bob":   {"name": "bob", "age": 42.0},
+			"carol": {"name": "carol", "age": 30.0},
+		},
+	}
+	// "age" is indexed, but only alice satisfies age==18.
+	ageKey, _ := json.Marshal(18)
+	src.indexed = map[string]map[string][]string{
+		"age": {string(ageKey): {"alice"}},
+	}
+	return src
+}
+
+// TestBuilder_OrClauseBypassesIndex ensures a query that ORs in a term the
+// index knows nothing about isn't narrowed to just the indexed clause's
+// hits, which would silently drop matching records (bob matches the OR
+// term but not age==18, so an index-only lookup would miss him).
+func TestBuilder_OrClauseBypassesIndex(t *testing.T) {
+	src := newPeopleSource()
+
+	var got []person
+	err := New(src, "people").
+		Where("age", EQ, 18).
+		Or("name", EQ, "bob").
+		Find(&got)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+
+	names := map[string]bool{}
+	for _, p := range got {
+		names[p.Name] = true
+	}
+	if !names["alice"] || !names["bob"] {
+		t.Fatalf("got %v, want both alice (age==18) and bob (name==bob)", got)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d results, want 2", len(got))
+	}
+}
+
+// TestBuilder_PureConjunctionUsesIndex ensures the index fast path still
+// applies when nothing is ORed in.
+func TestBuilder_PureConjunctionUsesIndex(t *testing.T) {
+	src := newPeopleSource()
+
+	var got []person
+	err := New(src, "people").
+		Where("age", EQ, 18).
+		And("name", EQ, "alice").
+		Find(&got)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "alice" {
+		t.Fatalf("got %v, want just alice", got)
+	}
+	if src.scanned {
+		t.Fatalf("Find scanned every resource instead of using the age index")
+	}
+}
@@ -0,0 +1,63 @@
+package jsondb
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/rocksolidlabs/jsondb/query"
+)
+
+// Query starts a query.Builder against collection, backed by db:
+//
+//	var people []*Person
+//	err := db.Query("people").Where("age", query.GTE, 18).Find(&people)
+func (db *JSONDB) Query(collection string) *query.Builder {
+	return query.New(db, collection)
+}
+
+// Resources implements query.Source.
+func (db *JSONDB) Resources(collection string) ([]string, error) {
+	codec := db.codecFor(collection)
+	dir := filepath.Join(db.Dir, collection)
+	suffix := "." + codec.Extension()
+
+	files := listResourceFiles(db.Fs, dir, codec.Extension())
+	resources := make([]string, 0, len(files))
+	for _, file := range files {
+		resources = append(resources, strings.TrimSuffix(file.Name(), suffix))
+	}
+	return resources, nil
+}
+
+// Data implements query.Source, decoding a resource generically so the
+// query builder can filter and sort on it.
+func (db *JSONDB) Data(collection, resource string) (map[string]interface{}, error) {
+	b, err := db.GetBytes(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{}
+	if err := db.codecFor(collection).Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Decode implements query.Source.
+func (db *JSONDB) Decode(collection, resource string, v interface{}) error {
+	return db.Get(collection, resource, v)
+}
+
+// IndexLookup implements query.Source, consulting the secondary index
+// created by CreateIndex for collection/path, if one exists.
+func (db *JSONDB) IndexLookup(collection, path string, value interface{}) ([]string, bool, error) {
+	idx, exists, err := db.loadIndex(collection, path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, nil
+	}
+	return idx[indexKey(value)], true, nil
+}
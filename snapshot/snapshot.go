@@ -0,0 +1,249 @@
+// Package snapshot implements a restic-style content-addressable blob store
+// on top of an afero.Fs. JSONDB uses it to give flat-file collections cheap,
+// immutable point-in-time snapshots, restores, and diffs without changing
+// its Put/Get API.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rocksolidlabs/afero"
+)
+
+// ID identifies a blob or a Snapshot by the SHA-256 hash of its contents.
+type ID string
+
+// Tree maps a resource name to the ID of the blob holding its contents.
+type Tree map[string]ID
+
+// Snapshot is an immutable record of every collection's Tree at a point in
+// time.
+type Snapshot struct {
+	ID    ID              `json:"-"`
+	Name  string          `json:"name"`
+	Time  time.Time       `json:"time"`
+	Trees map[string]Tree `json:"trees"`
+	// Exts records the on-disk extension (e.g. "json", "bson", "msgpack")
+	// each collection's codec was using at snapshot time, keyed by
+	// collection, so Restore can write resources back out in the same
+	// format they were read in.
+	Exts map[string]string `json:"exts"`
+}
+
+// Diff describes the resources that changed between two snapshots, keyed by
+// "<collection>/<resource>".
+type Diff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// Store persists blobs and snapshots under dir on fs:
+//
+//	<dir>/blobs/<first-2-hex>/<full-hex>    content-addressed record payloads
+//	<dir>/snapshots/<full-hex>.json         serialized Snapshot objects
+type Store struct {
+	Fs  afero.Fs
+	Dir string
+}
+
+// NewStore returns a Store rooted at dir on fs.
+func NewStore(fs afero.Fs, dir string) *Store {
+	return &Store{Fs: fs, Dir: dir}
+}
+
+func hashOf(data []byte) ID {
+	sum := sha256.Sum256(data)
+	return ID(hex.EncodeToString(sum[:]))
+}
+
+func (s *Store) blobPath(id ID) string {
+	return filepath.Join(s.Dir, "blobs", string(id)[:2], string(id))
+}
+
+// PutBlob writes data to the blob store and returns its ID. Repeated writes
+// of identical data are a no-op, since identical content hashes to the same
+// ID and is never rewritten.
+func (s *Store) PutBlob(data []byte) (ID, error) {
+	id := hashOf(data)
+	path := s.blobPath(id)
+
+	if _, err := s.Fs.Stat(path); err == nil {
+		return id, nil
+	}
+
+	if err := s.Fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	tmp := path + ".tmp"
+	if err := afero.WriteFile(s.Fs, tmp, data, 0644); err != nil {
+		return "", err
+	}
+	return id, s.Fs.Rename(tmp, path)
+}
+
+// GetBlob reads the payload stored under id.
+func (s *Store) GetBlob(id ID) ([]byte, error) {
+	return afero.ReadFile(s.Fs, s.blobPath(id))
+}
+
+func (s *Store) snapshotPath(id ID) string {
+	return filepath.Join(s.Dir, "snapshots", string(id)+".json")
+}
+
+// Save serializes trees into a new, immutable Snapshot and returns its ID.
+// exts records each collection's codec extension so Restore can recreate
+// its files in the same format.
+func (s *Store) Save(name string, trees map[string]Tree, exts map[string]string, now time.Time) (ID, error) {
+	snap := &Snapshot{Name: name, Time: now, Trees: trees, Exts: exts}
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return "", err
+	}
+	id := hashOf(b)
+
+	path := s.snapshotPath(id)
+	if err := s.Fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := afero.WriteFile(s.Fs, path, b, 0644); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Load reads back the Snapshot stored under id.
+func (s *Store) Load(id ID) (*Snapshot, error) {
+	b, err := afero.ReadFile(s.Fs, s.snapshotPath(id))
+	if err != nil {
+		return nil, err
+	}
+	snap := &Snapshot{}
+	if err := json.Unmarshal(b, snap); err != nil {
+		return nil, err
+	}
+	snap.ID = id
+	return snap, nil
+}
+
+// List returns every snapshot in the store, oldest first.
+func (s *Store) List() ([]*Snapshot, error) {
+	dir := filepath.Join(s.Dir, "snapshots")
+	files, err := afero.ReadDir(s.Fs, dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	snaps := make([]*Snapshot, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		id := ID(strings.TrimSuffix(file.Name(), ".json"))
+		snap, err := s.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Time.Before(snaps[j].Time) })
+	return snaps, nil
+}
+
+// DiffSnapshots compares two snapshots and reports which
+// "<collection>/<resource>" keys were added, removed, or changed between
+// them.
+func DiffSnapshots(a, b *Snapshot) Diff {
+	keysA := flatten(a)
+	keysB := flatten(b)
+
+	var d Diff
+	for key, idB := range keysB {
+		if idA, ok := keysA[key]; !ok {
+			d.Added = append(d.Added, key)
+		} else if idA != idB {
+			d.Changed = append(d.Changed, key)
+		}
+	}
+	for key := range keysA {
+		if _, ok := keysB[key]; !ok {
+			d.Removed = append(d.Removed, key)
+		}
+	}
+
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+func flatten(snap *Snapshot) map[string]ID {
+	out := map[string]ID{}
+	for collection, tree := range snap.Trees {
+		for resource, id := range tree {
+			out[filepath.Join(collection, resource)] = id
+		}
+	}
+	return out
+}
+
+// GC removes every blob not referenced by any live snapshot.
+func (s *Store) GC() error {
+	live := map[ID]bool{}
+
+	snaps, err := s.List()
+	if err != nil {
+		return err
+	}
+	for _, snap := range snaps {
+		for _, tree := range snap.Trees {
+			for _, id := range tree {
+				live[id] = true
+			}
+		}
+	}
+
+	blobsDir := filepath.Join(s.Dir, "blobs")
+	prefixes, err := afero.ReadDir(s.Fs, blobsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		if !prefix.IsDir() {
+			continue
+		}
+		prefixDir := filepath.Join(blobsDir, prefix.Name())
+		blobs, err := afero.ReadDir(s.Fs, prefixDir)
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			if live[ID(blob.Name())] {
+				continue
+			}
+			if err := s.Fs.Remove(filepath.Join(prefixDir, blob.Name())); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package jsondb
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rocksolidlabs/afero"
+	"github.com/rocksolidlabs/jsondb/snapshot"
+)
+
+// snapshotReservedDirs are top-level directories under db.Dir that hold
+// subsystem state rather than a collection, and so are skipped by Snapshot.
+var snapshotReservedDirs = map[string]bool{
+	"blobs":     true,
+	"snapshots": true,
+	"wal":       true,
+}
+
+// snapshotStore returns the Store backing db's snapshot subsystem, rooted
+// alongside its collections under db.Dir.
+func (db *JSONDB) snapshotStore() *snapshot.Store {
+	return snapshot.NewStore(db.Fs, db.Dir)
+}
+
+// Snapshot walks every collection in the database, content-addresses each
+// resource's bytes into the blob store (deduplicating identical records),
+// and saves the resulting set of per-collection trees as a new, immutable
+// snapshot. It returns the ID of the snapshot just created.
+func (db *JSONDB) Snapshot(name string) (snapshot.ID, error) {
+	store := db.snapshotStore()
+
+	entries, err := afero.ReadDir(db.Fs, db.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	trees := make(map[string]snapshot.Tree)
+	exts := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.IsDir() || snapshotReservedDirs[entry.Name()] {
+			continue
+		}
+		collection := entry.Name()
+
+		codec := db.codecFor(collection)
+		dir := filepath.Join(db.Dir, collection)
+		suffix := "." + codec.Extension()
+
+		tree := snapshot.Tree{}
+		for _, file := range listResourceFiles(db.Fs, dir, codec.Extension()) {
+			b, err := afero.ReadFile(db.Fs, filepath.Join(dir, file.Name()))
+			if err != nil {
+				return "", err
+			}
+			id, err := store.PutBlob(b)
+			if err != nil {
+				return "", err
+			}
+			tree[strings.TrimSuffix(file.Name(), suffix)] = id
+		}
+		trees[collection] = tree
+		exts[collection] = codec.Extension()
+	}
+
+	return store.Save(name, trees, exts, time.Now())
+}
+
+// Restore reconstitutes every resource recorded in snapshot id, writing each
+// one back out to <collection>/<resource>.<ext> exactly as it was when the
+// snapshot was taken, in the codec format that collection was using at the
+// time (recorded in the snapshot's Exts, not db's current Codec config). Any
+// resource that exists in the collection now but wasn't part of the
+// snapshot is removed, so the collection ends up matching snapshot state
+// exactly rather than just gaining back what the snapshot had.
+func (db *JSONDB) Restore(id snapshot.ID) error {
+	store := db.snapshotStore()
+
+	snap, err := store.Load(id)
+	if err != nil {
+		return err
+	}
+
+	for collection, tree := range snap.Trees {
+		dir := filepath.Join(db.Dir, collection)
+		if err := db.Fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		ext := snap.Exts[collection]
+		for resource, blobID := range tree {
+			b, err := store.GetBlob(blobID)
+			if err != nil {
+				return err
+			}
+			if err := afero.WriteFile(db.Fs, filepath.Join(dir, resource+"."+ext), b, 0644); err != nil {
+				return err
+			}
+		}
+
+		for _, file := range listResourceFiles(db.Fs, dir, ext) {
+			resource := strings.TrimSuffix(file.Name(), "."+ext)
+			if _, ok := tree[resource]; !ok {
+				if err := db.Fs.Remove(filepath.Join(dir, file.Name())); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// DiffSnapshots reports the resources added, removed, or changed between
+// two snapshots.
+func (db *JSONDB) DiffSnapshots(a, b snapshot.ID) (snapshot.Diff, error) {
+	store := db.snapshotStore()
+
+	snapA, err := store.Load(a)
+	if err != nil {
+		return snapshot.Diff{}, err
+	}
+	snapB, err := store.Load(b)
+	if err != nil {
+		return snapshot.Diff{}, err
+	}
+
+	return snapshot.DiffSnapshots(snapA, snapB), nil
+}
+
+// GC removes every blob not referenced by any of the database's live
+// snapshots.
+func (db *JSONDB) GC() error {
+	return db.snapshotStore().GC()
+}
@@ -0,0 +1,84 @@
+package jsondb
+
+import (
+	"testing"
+
+	"github.com/rocksolidlabs/afero"
+)
+
+type widget struct {
+	Name string `json:"name" bson:"name" msgpack:"name"`
+}
+
+// TestRestore_UsesCollectionCodecExtension ensures a non-JSON collection
+// survives a Snapshot/Restore round trip: Restore must write the resource
+// back with the codec's own extension, not a hardcoded ".json", or Get
+// (which looks for ".msgpack") can never find it again.
+func TestRestore_UsesCollectionCodecExtension(t *testing.T) {
+	db, err := NewJSONDBWithFs(afero.NewMemMapFs(), "/data", nil, false, JSONCodec{})
+	if err != nil {
+		t.Fatalf("NewJSONDBWithFs: %v", err)
+	}
+	db.RegisterCodec("widgets", MsgpackCodec{})
+
+	if err := db.Put("widgets", "w1", widget{Name: "sprocket"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	id, err := db.Snapshot("before-delete")
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	if err := db.Delete("widgets", "w1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if err := db.Restore(id); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	var got widget
+	if err := db.Get("widgets", "w1", &got); err != nil {
+		t.Fatalf("Get after Restore: %v (resource likely landed under the wrong extension)", err)
+	}
+	if got.Name != "sprocket" {
+		t.Fatalf("Name = %q, want %q", got.Name, "sprocket")
+	}
+}
+
+// TestRestore_RemovesResourcesAddedSinceSnapshot ensures Restore actually
+// rolls a collection back to snapshot state instead of only adding back
+// what the snapshot had: a resource written after the snapshot was taken
+// must be gone once Restore returns.
+func TestRestore_RemovesResourcesAddedSinceSnapshot(t *testing.T) {
+	db, err := NewJSONDBWithFs(afero.NewMemMapFs(), "/data", nil, false, JSONCodec{})
+	if err != nil {
+		t.Fatalf("NewJSONDBWithFs: %v", err)
+	}
+
+	if err := db.Put("widgets", "w1", widget{Name: "sprocket"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	id, err := db.Snapshot("before-new-widget")
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	if err := db.Put("widgets", "w2", widget{Name: "cog"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if err := db.Restore(id); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	var got widget
+	if err := db.Get("widgets", "w2", &got); err == nil {
+		t.Fatalf("Get(w2) after Restore succeeded with %+v, want it gone (not part of the restored snapshot)", got)
+	}
+	if err := db.Get("widgets", "w1", &got); err != nil {
+		t.Fatalf("Get(w1) after Restore: %v", err)
+	}
+}
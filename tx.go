@@ -0,0 +1,263 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rocksolidlabs/afero"
+)
+
+// txOpType identifies the kind of operation buffered in a Tx.
+type txOpType string
+
+const (
+	txOpPut    txOpType = "put"
+	txOpDelete txOpType = "delete"
+	txOpLink   txOpType = "link"
+)
+
+// txOp is a single buffered Tx operation. Value, when present, is already
+// encoded with the collection's Codec (set at Put time) so replay writes it
+// straight to disk instead of re-encoding a decoded interface{}, which would
+// lose the value's original type.
+type txOp struct {
+	Type           txOpType `json:"type"`
+	Collection     string   `json:"collection"`
+	Resource       string   `json:"resource"`
+	Value          []byte   `json:"value,omitempty"`
+	DestCollection string   `json:"dest_collection,omitempty"`
+	DestResource   string   `json:"dest_resource,omitempty"`
+}
+
+// lockKey returns the mutex key db.getOrCreateMutex must use to apply op
+// safely, matching the key each non-transactional method locks on its own.
+func (op txOp) lockKey() string {
+	if op.Type == txOpPut {
+		return op.Collection
+	}
+	return filepath.Join(op.Collection, op.Resource)
+}
+
+// walRecord is what actually gets written to a WAL file.
+type walRecord struct {
+	TxID string `json:"txid"`
+	Ops  []txOp `json:"ops"`
+}
+
+// Tx buffers a set of Put/Delete/Link operations for atomic application via
+// Commit. Nothing touches disk until Commit is called.
+type Tx struct {
+	db   *JSONDB
+	id   string
+	ops  []txOp
+	done bool
+}
+
+// Begin starts a new transaction against db.
+func (db *JSONDB) Begin() (*Tx, error) {
+	return &Tx{db: db, id: GenID(32)}, nil
+}
+
+// Put buffers a write of resource under collection, to be applied when the
+// transaction is committed. Behaves like JSONDB.Put: an empty resource gets
+// a generated name, assigned when Put is called; that name is not returned
+// to the caller.
+func (tx *Tx) Put(collection, resource string, v interface{}) error {
+	if tx.done {
+		return fmt.Errorf("jsondb: transaction already committed or rolled back")
+	}
+	if collection == "" {
+		return fmt.Errorf("Missing collection - no place to save record!")
+	}
+	if resource == "" {
+		resource = GenID(64)
+	}
+
+	b, err := tx.db.codecFor(collection).Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tx.ops = append(tx.ops, txOp{Type: txOpPut, Collection: collection, Resource: resource, Value: b})
+	return nil
+}
+
+// Delete buffers removal of collection/resource, to be applied when the
+// transaction is committed.
+func (tx *Tx) Delete(collection, resource string) error {
+	if tx.done {
+		return fmt.Errorf("jsondb: transaction already committed or rolled back")
+	}
+
+	tx.ops = append(tx.ops, txOp{Type: txOpDelete, Collection: collection, Resource: resource})
+	return nil
+}
+
+// Link buffers a link from destCollection/destResource to srcCollection/
+// srcResource, to be applied when the transaction is committed.
+func (tx *Tx) Link(srcCollection, srcResource, destCollection, destResource string) error {
+	if tx.done {
+		return fmt.Errorf("jsondb: transaction already committed or rolled back")
+	}
+
+	tx.ops = append(tx.ops, txOp{
+		Type:           txOpLink,
+		Collection:     srcCollection,
+		Resource:       srcResource,
+		DestCollection: destCollection,
+		DestResource:   destResource,
+	})
+	return nil
+}
+
+// Rollback discards every buffered operation. Since Commit is the only thing
+// that touches disk, there is nothing to undo.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return fmt.Errorf("jsondb: transaction already committed or rolled back")
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
+
+// Commit writes every buffered operation to a single WAL entry, fsyncs it,
+// applies the operations under a deterministically ordered set of collection
+// mutexes, and then removes the WAL entry. If the process dies between the
+// fsync and the removal, the next NewJSONDBWithFs call replays the entry so
+// the transaction still lands all-or-nothing.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("jsondb: transaction already committed or rolled back")
+	}
+	tx.done = true
+
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	db := tx.db
+	walDir := filepath.Join(db.Dir, "wal")
+	if err := db.Fs.MkdirAll(walDir, 0755); err != nil {
+		return err
+	}
+
+	record := walRecord{TxID: tx.id, Ops: tx.ops}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	walPath := filepath.Join(walDir, tx.id+".log")
+	if err := afero.WriteFile(db.Fs, walPath, b, 0644); err != nil {
+		return err
+	}
+	if f, err := db.Fs.OpenFile(walPath, os.O_RDWR, 0644); err == nil {
+		f.Sync()
+		f.Close()
+	}
+
+	return db.applyWAL(record, walPath)
+}
+
+// applyWAL locks every collection/resource an op in record touches, in
+// sorted order so concurrent transactions can never deadlock on each other,
+// applies the ops, and removes the WAL entry once they've all landed.
+func (db *JSONDB) applyWAL(record walRecord, walPath string) error {
+	keySet := map[string]bool{}
+	for _, op := range record.Ops {
+		keySet[op.lockKey()] = true
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	mutexes := make([]*sync.Mutex, len(keys))
+	for i, k := range keys {
+		mutexes[i] = db.getOrCreateMutex(k)
+	}
+	for _, m := range mutexes {
+		m.Lock()
+	}
+	defer func() {
+		for _, m := range mutexes {
+			m.Unlock()
+		}
+	}()
+
+	for _, op := range record.Ops {
+		if err := db.applyTxOp(op); err != nil {
+			return err
+		}
+	}
+
+	return db.Fs.Remove(walPath)
+}
+
+func (db *JSONDB) applyTxOp(op txOp) error {
+	switch op.Type {
+	case txOpPut:
+		return db.putLockedBytes(op.Collection, op.Resource, op.Value)
+	case txOpDelete:
+		path := filepath.Join(op.Collection, op.Resource)
+		if _, err := db.Stat(filepath.Join(db.Dir, path), db.codecFor(op.Collection).Extension()); err != nil {
+			// already gone: either this is a WAL replay of a delete that
+			// landed before the crash, or Commit is re-applying a tx whose
+			// ops partially succeeded. Either way the end state is what the
+			// op wanted, so treat it as done rather than failing replay.
+			return nil
+		}
+		return db.deleteLocked(op.Collection, op.Resource)
+	case txOpLink:
+		return db.linkLocked(op.Collection, op.Resource, op.DestCollection, op.DestResource)
+	}
+	return fmt.Errorf("jsondb: unknown tx op type %q", op.Type)
+}
+
+// replayWAL applies any WAL entries left behind by a transaction that
+// committed but was interrupted before its entry could be removed, so a
+// restart finishes what Commit started instead of losing it.
+func (db *JSONDB) replayWAL() error {
+	walDir := filepath.Join(db.Dir, "wal")
+
+	entries, err := afero.ReadDir(db.Fs, walDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+
+		walPath := filepath.Join(walDir, entry.Name())
+		b, err := afero.ReadFile(db.Fs, walPath)
+		if err != nil {
+			return err
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(b, &record); err != nil {
+			// left behind by a crash mid-write; there's nothing coherent to
+			// replay, so drop it rather than block startup on it forever
+			db.Fs.Remove(walPath)
+			continue
+		}
+
+		if err := db.applyWAL(record, walPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/rocksolidlabs/afero"
+)
+
+type txTestRecord struct {
+	Name string `json:"name"`
+}
+
+func newTxTestDB(t *testing.T) *JSONDB {
+	t.Helper()
+	db, err := NewJSONDBWithFs(afero.NewMemMapFs(), "/data", nil, false, JSONCodec{})
+	if err != nil {
+		t.Fatalf("NewJSONDBWithFs: %v", err)
+	}
+	return db
+}
+
+// TestReplayWAL_TolerantOfAlreadyAppliedDelete simulates the crash window
+// Commit can't close: every op has already landed but the WAL entry for the
+// transaction wasn't removed yet. Replaying it again must not fail just
+// because the delete's target is already gone.
+func TestReplayWAL_TolerantOfAlreadyAppliedDelete(t *testing.T) {
+	db := newTxTestDB(t)
+
+	if err := db.Put("widgets", "a", txTestRecord{Name: "a"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := db.Delete("widgets", "a"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	// the resource is already gone; replay the same delete op as if the WAL
+	// entry survived a crash after the delete but before its own removal.
+	record := walRecord{TxID: "crash-replay", Ops: []txOp{
+		{Type: txOpDelete, Collection: "widgets", Resource: "a"},
+	}}
+	walPath := filepath.Join(db.Dir, "wal", "crash-replay.log")
+	b, err := json.Marshal(record)
+	if err != nil {
+		t.Fatalf("marshal WAL record: %v", err)
+	}
+	if err := db.Fs.MkdirAll(filepath.Dir(walPath), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := afero.WriteFile(db.Fs, walPath, b, 0644); err != nil {
+		t.Fatalf("write WAL entry: %v", err)
+	}
+	if err := db.applyWAL(record, walPath); err != nil {
+		t.Fatalf("applyWAL should be a no-op for an already-applied delete, got: %v", err)
+	}
+}
+
+// TestTxCommit_PreservesInt64Precision verifies that a value buffered via
+// Tx.Put and applied through Commit's WAL path lands with the same int64
+// value it started with, instead of round-tripping through a generic
+// interface{} and becoming a float64.
+func TestTxCommit_PreservesInt64Precision(t *testing.T) {
+	type counter struct {
+		Count int64 `json:"count"`
+	}
+
+	db := newTxTestDB(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if err := tx.Put("counters", "c1", counter{Count: 9007199254740993}); err != nil {
+		t.Fatalf("Tx.Put: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	var got counter
+	if err := db.Get("counters", "c1", &got); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Count != 9007199254740993 {
+		t.Fatalf("Count = %d, want 9007199254740993 (precision lost in round-trip)", got.Count)
+	}
+}